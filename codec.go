@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Codec encodes and decodes records for storage and names the file
+// extension records are stored under, letting a Driver swap its on-disk
+// format without changing any of the collection/resource semantics built on
+// top of it.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	Ext() string
+}
+
+// JSONCodec is the driver's original format: indented JSON, one record per
+// file. It's the default when Options.Codec is unset.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", " \t")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (JSONCodec) Ext() string                                { return ".json" }
+
+// BSONCodec stores records as BSON documents, giving binary fields (raw
+// bytes, time.Time) a clean round trip that JSON's text encoding can't offer.
+type BSONCodec struct{}
+
+func (BSONCodec) Marshal(v interface{}) ([]byte, error)      { return bson.Marshal(v) }
+func (BSONCodec) Unmarshal(data []byte, v interface{}) error { return bson.Unmarshal(data, v) }
+func (BSONCodec) Ext() string                                { return ".bson" }
+
+// CBORCodec stores records as CBOR, a compact binary JSON-equivalent.
+type CBORCodec struct{}
+
+func (CBORCodec) Marshal(v interface{}) ([]byte, error)      { return cbor.Marshal(v) }
+func (CBORCodec) Unmarshal(data []byte, v interface{}) error { return cbor.Unmarshal(data, v) }
+func (CBORCodec) Ext() string                                { return ".cbor" }
+
+// MsgpackCodec stores records as MessagePack.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+func (MsgpackCodec) Ext() string                                { return ".msgpack" }
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+type codecTestRecord struct {
+	Name string
+	Age  int
+}
+
+// TestNonJSONCodecRoundTrip writes and reads a record back through a
+// non-default Codec, proving the Driver (not just the codec in isolation)
+// round-trips correctly when Options.Codec is set.
+func TestNonJSONCodecRoundTrip(t *testing.T) {
+	db, err := New(t.TempDir(), &Options{Codec: MsgpackCodec{}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	want := codecTestRecord{Name: "alice", Age: 30}
+	if err := db.Write("users", "alice", want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var got codecTestRecord
+	if err := db.Read("users", "alice", &got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadAllInto decodes every record in collection into a []T, replacing the
+// ReadAll-then-json.Unmarshal-each-record pattern callers otherwise have to
+// write by hand.
+func ReadAllInto[T any](d *Driver, collection string) ([]T, error) {
+	var out []T
+	err := Iterate(d, collection, func(_ string, v T) error {
+		out = append(out, v)
+		return nil
+	})
+	return out, err
+}
+
+// Iterate calls fn for every record in collection, in directory order,
+// decoding one file at a time rather than reading the whole collection into
+// memory up front the way ReadAll does.
+func Iterate[T any](d *Driver, collection string, fn func(resource string, v T) error) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - unable to read record!")
+	}
+
+	collLock := d.getCollectionLock(collection)
+	collLock.RLock()
+	defer collLock.RUnlock()
+
+	dir := filepath.Join(d.dir, collection)
+	if _, err := d.stat(dir); err != nil {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+
+		v, err := decodeFile[T](d.codec, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := fn(strings.TrimSuffix(name, filepath.Ext(name)), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadInto decodes resource within collection into a T.
+func ReadInto[T any](d *Driver, collection, resource string) (T, error) {
+	var v T
+	if collection == "" {
+		return v, fmt.Errorf("Missing collection - no place to save record!")
+	}
+	if resource == "" {
+		return v, fmt.Errorf("Missing resource - unable to save record!")
+	}
+
+	collLock := d.getCollectionLock(collection)
+	collLock.RLock()
+	defer collLock.RUnlock()
+
+	resLock := d.getResourceLock(collection, resource)
+	resLock.RLock()
+	defer resLock.RUnlock()
+
+	b, err := d.readRaw(collection, resource)
+	if err != nil {
+		return v, err
+	}
+	return v, d.codec.Unmarshal(b, &v)
+}
+
+// decodeFile opens path once and decodes it into a T. For the default
+// JSONCodec it decodes straight off the open file with json.Decoder; other
+// codecs only expose a []byte-based Unmarshal, so they read the (single,
+// already-small) record fully before decoding.
+func decodeFile[T any](codec Codec, path string) (T, error) {
+	var v T
+
+	f, err := os.Open(path)
+	if err != nil {
+		return v, err
+	}
+	defer f.Close()
+
+	if _, ok := codec.(JSONCodec); ok {
+		return v, json.NewDecoder(f).Decode(&v)
+	}
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return v, err
+	}
+	return v, codec.Unmarshal(b, &v)
+}
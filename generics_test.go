@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+type genericsTestUser struct {
+	Name string
+	Age  int
+}
+
+// TestReadAllIntoAndIterate writes several typed records and confirms both
+// ReadAllInto (collect-all) and Iterate (one-at-a-time, with the resource
+// name) decode every one of them correctly.
+func TestReadAllIntoAndIterate(t *testing.T) {
+	db, err := New(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	want := []genericsTestUser{
+		{Name: "alice", Age: 30},
+		{Name: "bob", Age: 25},
+	}
+	for _, u := range want {
+		if err := db.Write("users", u.Name, u); err != nil {
+			t.Fatalf("Write(%s): %v", u.Name, err)
+		}
+	}
+
+	got, err := ReadAllInto[genericsTestUser](db, "users")
+	if err != nil {
+		t.Fatalf("ReadAllInto: %v", err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Name < got[j].Name })
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("ReadAllInto got %v, want %v", got, want)
+	}
+
+	seen := map[string]genericsTestUser{}
+	err = Iterate(db, "users", func(resource string, v genericsTestUser) error {
+		seen[resource] = v
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate: %v", err)
+	}
+	if len(seen) != 2 || seen["alice"].Age != 30 || seen["bob"].Age != 25 {
+		t.Fatalf("Iterate saw %v, want alice=30 and bob=25", seen)
+	}
+}
+
+// TestReadInto decodes a single resource by its typed helper.
+func TestReadInto(t *testing.T) {
+	db, err := New(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	want := genericsTestUser{Name: "carol", Age: 40}
+	if err := db.Write("users", "carol", want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	got, err := ReadInto[genericsTestUser](db, "users", "carol")
+	if err != nil {
+		t.Fatalf("ReadInto: %v", err)
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := ReadInto[genericsTestUser](db, "users", "missing"); err == nil {
+		t.Fatal("ReadInto of a missing resource should return an error")
+	}
+}
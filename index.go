@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// indexManifestFile is the name of the per-collection file listing which
+// fields have a live index, so CreateIndex survives a restart.
+const indexManifestFile = ".indexes.json"
+
+// indexEntry holds every resource whose field equals Value, keeping
+// fieldIndex sorted by Value for binary-search seeks.
+type indexEntry struct {
+	Value     interface{}
+	Resources []string
+}
+
+// fieldIndex is a sorted value -> resource-names index for a single field of
+// a single collection.
+type fieldIndex struct {
+	mu      sync.RWMutex
+	entries []indexEntry
+}
+
+func (idx *fieldIndex) insert(value interface{}, resource string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	i := sort.Search(len(idx.entries), func(i int) bool { return compareValues(idx.entries[i].Value, value) >= 0 })
+	if i < len(idx.entries) && compareValues(idx.entries[i].Value, value) == 0 {
+		for _, r := range idx.entries[i].Resources {
+			if r == resource {
+				return
+			}
+		}
+		idx.entries[i].Resources = append(idx.entries[i].Resources, resource)
+		return
+	}
+
+	idx.entries = append(idx.entries, indexEntry{})
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = indexEntry{Value: value, Resources: []string{resource}}
+}
+
+// removeResource drops resource from whichever entry currently holds it.
+// The caller may not know resource's old value (e.g. an overwrite), so this
+// scans every entry rather than seeking.
+func (idx *fieldIndex) removeResource(resource string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for i := range idx.entries {
+		for j, r := range idx.entries[i].Resources {
+			if r != resource {
+				continue
+			}
+			idx.entries[i].Resources = append(idx.entries[i].Resources[:j], idx.entries[i].Resources[j+1:]...)
+			if len(idx.entries[i].Resources) == 0 {
+				idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+			}
+			return
+		}
+	}
+}
+
+// seekEq returns the resources whose indexed field equals value.
+func (idx *fieldIndex) seekEq(value interface{}) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	i := sort.Search(len(idx.entries), func(i int) bool { return compareValues(idx.entries[i].Value, value) >= 0 })
+	if i >= len(idx.entries) || compareValues(idx.entries[i].Value, value) != 0 {
+		return nil
+	}
+
+	out := make([]string, len(idx.entries[i].Resources))
+	copy(out, idx.entries[i].Resources)
+	return out
+}
+
+// CreateIndex builds an in-memory index over field within collection and
+// registers it so future Write/Delete calls keep it up to date. The index is
+// rebuilt by walking the collection once; it's also recorded in the
+// collection's index manifest so New can rebuild it after a restart.
+func (d *Driver) CreateIndex(collection, field string) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - no place to index!")
+	}
+	if field == "" {
+		return fmt.Errorf("Missing field - nothing to index!")
+	}
+
+	collLock := d.getCollectionLock(collection)
+	collLock.Lock()
+	defer collLock.Unlock()
+
+	idx, err := d.buildIndex(collection, field)
+	if err != nil {
+		return err
+	}
+	d.registerIndex(collection, field, idx)
+
+	if err := os.MkdirAll(filepath.Join(d.dir, collection), 0755); err != nil {
+		return err
+	}
+
+	fields, err := d.loadIndexManifest(collection)
+	if err != nil {
+		return err
+	}
+	if !containsString(fields, field) {
+		fields = append(fields, field)
+	}
+	return d.saveIndexManifest(collection, fields)
+}
+
+// buildIndex walks collection once and extracts field from every record via
+// reflection over its decoded JSON representation.
+func (d *Driver) buildIndex(collection, field string) (*fieldIndex, error) {
+	names, records, err := d.listRecords(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &fieldIndex{}
+	for i, name := range names {
+		value, ok, err := d.fieldValue(records[i], field)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			idx.insert(value, name)
+		}
+	}
+	return idx, nil
+}
+
+func (d *Driver) registerIndex(collection, field string, idx *fieldIndex) {
+	d.indexMu.Lock()
+	defer d.indexMu.Unlock()
+	if d.indexes[collection] == nil {
+		d.indexes[collection] = make(map[string]*fieldIndex)
+	}
+	d.indexes[collection][field] = idx
+}
+
+func (d *Driver) getIndex(collection, field string) (*fieldIndex, bool) {
+	d.indexMu.RLock()
+	defer d.indexMu.RUnlock()
+	idx, ok := d.indexes[collection][field]
+	return idx, ok
+}
+
+// updateIndexesOnWrite keeps every index registered for collection in sync
+// with a write of resource, replacing any stale entry left by a prior value.
+func (d *Driver) updateIndexesOnWrite(collection, resource string, data []byte) {
+	d.indexMu.RLock()
+	fields := d.indexes[collection]
+	d.indexMu.RUnlock()
+
+	for field, idx := range fields {
+		idx.removeResource(resource)
+		if value, ok, err := d.fieldValue(data, field); err == nil && ok {
+			idx.insert(value, resource)
+		}
+	}
+}
+
+// updateIndexesOnDelete keeps every index registered for collection in sync
+// with a delete of resource.
+func (d *Driver) updateIndexesOnDelete(collection, resource string) {
+	d.indexMu.RLock()
+	fields := d.indexes[collection]
+	d.indexMu.RUnlock()
+
+	for _, idx := range fields {
+		idx.removeResource(resource)
+	}
+}
+
+// loadIndexes rebuilds every collection's registered indexes from its index
+// manifest, if any. It's called once, from New.
+func (d *Driver) loadIndexes() {
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		collection := entry.Name()
+		fields, err := d.loadIndexManifest(collection)
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+
+		for _, field := range fields {
+			idx, err := d.buildIndex(collection, field)
+			if err != nil {
+				d.log.Error("Failed to rebuild index on '%s.%s': %v\n", collection, field, err)
+				continue
+			}
+			d.registerIndex(collection, field, idx)
+		}
+	}
+}
+
+type indexManifest struct {
+	Fields []string `json:"fields"`
+}
+
+func indexManifestPath(dir, collection string) string {
+	return filepath.Join(dir, collection, indexManifestFile)
+}
+
+func (d *Driver) loadIndexManifest(collection string) ([]string, error) {
+	b, err := ioutil.ReadFile(indexManifestPath(d.dir, collection))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var manifest indexManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest.Fields, nil
+}
+
+func (d *Driver) saveIndexManifest(collection string, fields []string) error {
+	b, err := json.MarshalIndent(indexManifest{Fields: fields}, "", " \t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(indexManifestPath(d.dir, collection), b, 0644)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldValue extracts field from a record's decoded representation via
+// reflection, reporting whether the field was present. Decoding goes through
+// the driver's codec, so this works the same whichever format records are
+// stored in.
+func (d *Driver) fieldValue(data []byte, field string) (interface{}, bool, error) {
+	var decoded map[string]interface{}
+	if err := d.codec.Unmarshal(data, &decoded); err != nil {
+		return nil, false, err
+	}
+
+	rv := reflect.ValueOf(decoded).MapIndex(reflect.ValueOf(field))
+	if !rv.IsValid() {
+		return nil, false, nil
+	}
+	return rv.Interface(), true, nil
+}
+
+// compareValues orders two decoded field values, returning <0, 0, or >0. It
+// normalizes across the numeric types different codecs decode into (JSON's
+// float64, but also the ints BSON/CBOR/Msgpack may produce) and falls back
+// to comparing string forms for anything else.
+func compareValues(a, b interface{}) int {
+	if an, ok := toFloat64(a); ok {
+		if bn, ok := toFloat64(b); ok {
+			switch {
+			case an < bn:
+				return -1
+			case an > bn:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	switch av := a.(type) {
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv)
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0
+			case bv:
+				return -1
+			default:
+				return 1
+			}
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// toFloat64 reports whether v is a numeric kind and, if so, its value as a
+// float64.
+func toFloat64(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	}
+	return 0, false
+}
@@ -6,8 +6,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/jcelliott/lumber"
 )
 
@@ -24,14 +26,30 @@ type (
 	}
 
 	Driver struct {
-		mutex   sync.Mutex
-		mutexes map[string]*sync.Mutex
-		dir     string
-		log     Logger
+		collMu    sync.Mutex
+		collLocks map[string]*sync.RWMutex
+
+		resMu    sync.Mutex
+		resLocks map[string]*sync.RWMutex
+
+		dir string
+		log Logger
+
+		indexMu sync.RWMutex
+		indexes map[string]map[string]*fieldIndex
+
+		codec Codec
+
+		watchMu     sync.Mutex
+		watchers    map[string]*collectionWatcher
+		rootWatcher *fsnotify.Watcher
+		allSubsMu   sync.Mutex
+		allSubs     []chan Event
 	}
 )
 type Options struct {
 	Logger
+	Codec Codec
 }
 
 func New(dir string, options *Options) (*Driver, error) {
@@ -46,20 +64,33 @@ func New(dir string, options *Options) (*Driver, error) {
 	if opts.Logger == nil {
 		opts.Logger = lumber.NewConsoleLogger(lumber.INFO)
 	}
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec{}
+	}
 
 	driver := Driver{
-		dir:     dir,
-		mutexes: make(map[string]*sync.Mutex),
-		log:     opts.Logger,
+		dir:       dir,
+		collLocks: make(map[string]*sync.RWMutex),
+		resLocks:  make(map[string]*sync.RWMutex),
+		log:       opts.Logger,
+		indexes:   make(map[string]map[string]*fieldIndex),
+		codec:     opts.Codec,
 	}
 
 	if _, err := os.Stat(dir); err == nil {
 		opts.Logger.Debug("Using '%s' (database already exists) \n", dir)
+		driver.recoverWAL()
+		driver.loadIndexes()
 		return &driver, nil
 	}
 
 	opts.Logger.Debug("Creating the database at '%s'\n", dir)
-	return &driver, os.MkdirAll(dir, 0755)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return &driver, err
+	}
+	driver.recoverWAL()
+	driver.loadIndexes()
+	return &driver, nil
 }
 
 func (d *Driver) Write(collection, resource string, v interface{}) error {
@@ -70,24 +101,37 @@ func (d *Driver) Write(collection, resource string, v interface{}) error {
 		return fmt.Errorf("Missing resource - no key to save record!")
 	}
 
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	collLock := d.getCollectionLock(collection)
+	collLock.RLock()
+	defer collLock.RUnlock()
 
-	dir := filepath.Join(d.dir, collection)
-	fnlPath := filepath.Join(dir, resource+".json")
-	tmpPath := fnlPath + ".tmp"
+	resLock := d.getResourceLock(collection, resource)
+	resLock.Lock()
+	defer resLock.Unlock()
 
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	b, err := d.codec.Marshal(v)
+	if err != nil {
 		return err
 	}
 
-	b, err := json.MarshalIndent(v, "", " \t")
-	if err != nil {
+	if err := writeRecord(d.dir, collection, resource, d.codec.Ext(), b); err != nil {
 		return err
 	}
+	d.updateIndexesOnWrite(collection, resource, b)
+	return nil
+}
 
-	b = append(b, byte('\n'))
+// writeRecord stages b in a temp file and atomically renames it into place,
+// creating the collection directory if needed. Callers must hold any locks
+// required to serialize access to collection.
+func writeRecord(dir, collection, resource, ext string, b []byte) error {
+	collectionDir := filepath.Join(dir, collection)
+	fnlPath := filepath.Join(collectionDir, resource+ext)
+	tmpPath := fnlPath + ".tmp"
+
+	if err := os.MkdirAll(collectionDir, 0755); err != nil {
+		return err
+	}
 
 	if err := ioutil.WriteFile(tmpPath, b, 0644); err != nil {
 		return err
@@ -104,18 +148,31 @@ func (d *Driver) Read(collection, resource string, v interface{}) error {
 		return fmt.Errorf("Missing resource - unable to save record!")
 	}
 
-	record := filepath.Join(d.dir, collection, resource+".json")
+	collLock := d.getCollectionLock(collection)
+	collLock.RLock()
+	defer collLock.RUnlock()
 
-	if _, err := stat(record); err != nil {
-		return err
-	}
+	resLock := d.getResourceLock(collection, resource)
+	resLock.RLock()
+	defer resLock.RUnlock()
 
-	b, err := ioutil.ReadFile(record)
+	b, err := d.readRaw(collection, resource)
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(b, &v)
+	return d.codec.Unmarshal(b, v)
+}
+
+// readRaw returns the raw encoded bytes of resource within collection.
+func (d *Driver) readRaw(collection, resource string) ([]byte, error) {
+	record := filepath.Join(d.dir, collection, resource+d.codec.Ext())
+
+	if _, err := d.stat(record); err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadFile(record)
 }
 
 func (d *Driver) ReadAll(collection string) ([]string, error) {
@@ -123,57 +180,121 @@ func (d *Driver) ReadAll(collection string) ([]string, error) {
 		return nil, fmt.Errorf("Missing collection - unable to read record!")
 	}
 
+	collLock := d.getCollectionLock(collection)
+	collLock.RLock()
+	defer collLock.RUnlock()
+
+	_, data, err := d.listRecords(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]string, len(data))
+	for i, b := range data {
+		records[i] = string(b)
+	}
+	return records, nil
+}
+
+// listRecords reads every record in collection, returning resource names
+// alongside their raw encoded bytes. Housekeeping files written alongside
+// records (temp files from an in-flight Write, the index manifest) are
+// skipped.
+func (d *Driver) listRecords(collection string) ([]string, [][]byte, error) {
 	dir := filepath.Join(d.dir, collection)
-	records := []string{}
+	names := []string{}
+	records := [][]byte{}
 
-	if _, err := stat(dir); err != nil {
-		return records, nil
+	if _, err := d.stat(dir); err != nil {
+		return names, records, nil
 	}
 
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	for _, file := range files {
-		b, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		name := file.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		records = append(records, string(b))
+		names = append(names, strings.TrimSuffix(name, filepath.Ext(name)))
+		records = append(records, b)
 	}
-	return records, nil
+	return names, records, nil
 }
 
 func (d *Driver) Delete(collection, resource string) error {
-	path := filepath.Join(d.dir, collection, resource)
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	collLock := d.getCollectionLock(collection)
+	collLock.RLock()
+	defer collLock.RUnlock()
+
+	resLock := d.getResourceLock(collection, resource)
+	resLock.Lock()
+	defer resLock.Unlock()
+
+	if err := deleteRecord(d.dir, collection, resource, d.codec.Ext()); err != nil {
+		return err
+	}
+	d.updateIndexesOnDelete(collection, resource)
+	return nil
+}
+
+// deleteRecord removes the record file for resource from collection.
+// Callers must hold any locks required to serialize access to collection.
+func deleteRecord(dir, collection, resource, ext string) error {
+	path := filepath.Join(dir, collection, resource)
 
-	switch fi, err := stat(path + ".json"); {
+	switch fi, err := os.Stat(path + ext); {
 	case fi == nil, err != nil:
 		return fmt.Errorf("Unable to find file or directory named %v\n", path)
 	case fi.Mode().IsRegular():
-		return os.Remove(path + ".json")
+		return os.Remove(path + ext)
 	}
 	return nil
 }
 
-func (d *Driver) getOrCreateMutex(collection string) *sync.Mutex {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	m, ok := d.mutexes[collection]
+// getCollectionLock returns the RWMutex guarding directory-level invariants
+// (the collection's existence, its set of resource files) for collection.
+// It's held for read during any operation on the collection, including
+// Write/Delete, which additionally take the resource's own lock for write.
+func (d *Driver) getCollectionLock(collection string) *sync.RWMutex {
+	d.collMu.Lock()
+	defer d.collMu.Unlock()
+	l, ok := d.collLocks[collection]
+	if !ok {
+		l = &sync.RWMutex{}
+		d.collLocks[collection] = l
+	}
+	return l
+}
+
+// getResourceLock returns the RWMutex guarding a single resource within
+// collection, so Writes/Deletes of independent resources don't serialize
+// against each other and Reads of a resource don't block Reads of another.
+func (d *Driver) getResourceLock(collection, resource string) *sync.RWMutex {
+	key := collection + "/" + resource
+	d.resMu.Lock()
+	defer d.resMu.Unlock()
+	l, ok := d.resLocks[key]
 	if !ok {
-		m = &sync.Mutex{}
-		d.mutexes[collection] = m
+		l = &sync.RWMutex{}
+		d.resLocks[key] = l
 	}
-	return m
+	return l
 }
 
-func stat(path string) (fi os.FileInfo, err error) {
+// stat checks whether path exists, falling back to path plus the driver's
+// codec extension for callers that pass an extension-less path.
+func (d *Driver) stat(path string) (fi os.FileInfo, err error) {
 	if fi, err = os.Stat(path); os.IsNotExist(err) {
-		fi, err = os.Stat(path + ".json")
+		fi, err = os.Stat(path + d.codec.Ext())
 	}
 	return
 }
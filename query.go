@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// whereClause is a single predicate staged on a Query.
+type whereClause struct {
+	field string
+	op    string
+	value interface{}
+}
+
+// Query is a chainable builder for reading a filtered, ordered, paginated
+// slice of a collection. Build one with Driver.Query.
+type Query struct {
+	driver     *Driver
+	collection string
+	wheres     []whereClause
+	orderField string
+	orderAsc   bool
+	limit      int
+	offset     int
+}
+
+// Query starts a query against collection.
+func (d *Driver) Query(collection string) *Query {
+	return &Query{driver: d, collection: collection, limit: -1}
+}
+
+// Where stages a predicate. op is one of "=", "!=", "<", "<=", ">", ">=".
+func (q *Query) Where(field, op string, value interface{}) *Query {
+	q.wheres = append(q.wheres, whereClause{field: field, op: op, value: value})
+	return q
+}
+
+// OrderBy sorts results by field, ascending if asc is true.
+func (q *Query) OrderBy(field string, asc bool) *Query {
+	q.orderField = field
+	q.orderAsc = asc
+	return q
+}
+
+// Limit caps the number of results returned.
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// Offset skips the first k results.
+func (q *Query) Offset(k int) *Query {
+	q.offset = k
+	return q
+}
+
+// Into runs the query and decodes the matching records into slice, which
+// must be a pointer to a slice of the target record type.
+func (q *Query) Into(slice interface{}) error {
+	rv := reflect.ValueOf(slice)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("Into expects a pointer to a slice, got %T", slice)
+	}
+
+	records, err := q.run()
+	if err != nil {
+		return err
+	}
+
+	elemType := rv.Elem().Type().Elem()
+	out := reflect.MakeSlice(rv.Elem().Type(), 0, len(records))
+	for _, record := range records {
+		item := reflect.New(elemType)
+		if err := q.driver.codec.Unmarshal(record, item.Interface()); err != nil {
+			return err
+		}
+		out = reflect.Append(out, item.Elem())
+	}
+	rv.Elem().Set(out)
+	return nil
+}
+
+// run resolves the candidate record set (via an index seek when possible),
+// applies every Where clause, sorts, and paginates.
+func (q *Query) run() ([][]byte, error) {
+	candidates, err := q.candidates()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([][]byte, 0, len(candidates))
+	for _, record := range candidates {
+		if q.matches(record) {
+			matched = append(matched, record)
+		}
+	}
+
+	if q.orderField != "" {
+		sort.SliceStable(matched, func(i, j int) bool {
+			vi, _, _ := q.driver.fieldValue(matched[i], q.orderField)
+			vj, _, _ := q.driver.fieldValue(matched[j], q.orderField)
+			cmp := compareValues(vi, vj)
+			if q.orderAsc {
+				return cmp < 0
+			}
+			return cmp > 0
+		})
+	}
+
+	if q.offset > 0 {
+		if q.offset >= len(matched) {
+			return nil, nil
+		}
+		matched = matched[q.offset:]
+	}
+	if q.limit >= 0 && q.limit < len(matched) {
+		matched = matched[:q.limit]
+	}
+	return matched, nil
+}
+
+// candidates returns the records to filter: an index seek's resources if an
+// equality Where on an indexed field narrows things down, otherwise every
+// record in the collection.
+func (q *Query) candidates() ([][]byte, error) {
+	collLock := q.driver.getCollectionLock(q.collection)
+	collLock.RLock()
+	defer collLock.RUnlock()
+
+	for _, w := range q.wheres {
+		if w.op != "=" {
+			continue
+		}
+		idx, ok := q.driver.getIndex(q.collection, w.field)
+		if !ok {
+			continue
+		}
+
+		var out [][]byte
+		for _, resource := range idx.seekEq(w.value) {
+			resLock := q.driver.getResourceLock(q.collection, resource)
+			resLock.RLock()
+			b, err := q.driver.readRaw(q.collection, resource)
+			resLock.RUnlock()
+			if err != nil {
+				continue
+			}
+			out = append(out, b)
+		}
+		return out, nil
+	}
+
+	_, records, err := q.driver.listRecords(q.collection)
+	return records, err
+}
+
+// matches reports whether record satisfies every Where clause.
+func (q *Query) matches(record []byte) bool {
+	for _, w := range q.wheres {
+		value, ok, err := q.driver.fieldValue(record, w.field)
+		if err != nil || !ok {
+			return false
+		}
+
+		cmp := compareValues(value, w.value)
+		switch w.op {
+		case "=":
+			if cmp != 0 {
+				return false
+			}
+		case "!=":
+			if cmp == 0 {
+				return false
+			}
+		case "<":
+			if cmp >= 0 {
+				return false
+			}
+		case "<=":
+			if cmp > 0 {
+				return false
+			}
+		case ">":
+			if cmp <= 0 {
+				return false
+			}
+		case ">=":
+			if cmp < 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+type queryTestUser struct {
+	Name    string
+	Company string
+}
+
+// TestQueryUnindexed exercises a Where against a field with no CreateIndex
+// call, which must fall back to scanning every record in the collection.
+func TestQueryUnindexed(t *testing.T) {
+	db, err := New(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	users := []queryTestUser{
+		{Name: "alice", Company: "Acme"},
+		{Name: "bob", Company: "Acme"},
+		{Name: "carol", Company: "Initech"},
+	}
+	for _, u := range users {
+		if err := db.Write("users", u.Name, u); err != nil {
+			t.Fatalf("Write(%s): %v", u.Name, err)
+		}
+	}
+
+	var out []queryTestUser
+	if err := db.Query("users").Where("Company", "=", "Acme").Into(&out); err != nil {
+		t.Fatalf("Into: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("got %d results, want 2: %v", len(out), out)
+	}
+}
+
+// TestQueryIndexed exercises the same query after CreateIndex, which should
+// take the index-seek path in candidates rather than the full scan, and
+// must return the same results as the unindexed case.
+func TestQueryIndexed(t *testing.T) {
+	db, err := New(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	users := []queryTestUser{
+		{Name: "alice", Company: "Acme"},
+		{Name: "bob", Company: "Acme"},
+		{Name: "carol", Company: "Initech"},
+	}
+	for _, u := range users {
+		if err := db.Write("users", u.Name, u); err != nil {
+			t.Fatalf("Write(%s): %v", u.Name, err)
+		}
+	}
+
+	if err := db.CreateIndex("users", "Company"); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+
+	var out []queryTestUser
+	if err := db.Query("users").Where("Company", "=", "Acme").Into(&out); err != nil {
+		t.Fatalf("Into: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("got %d results, want 2: %v", len(out), out)
+	}
+
+	// A write after the index exists must keep the index (and therefore the
+	// query results) in sync.
+	if err := db.Write("users", "dave", queryTestUser{Name: "dave", Company: "Acme"}); err != nil {
+		t.Fatalf("Write(dave): %v", err)
+	}
+	out = nil
+	if err := db.Query("users").Where("Company", "=", "Acme").Into(&out); err != nil {
+		t.Fatalf("Into after write: %v", err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("got %d results after write, want 3: %v", len(out), out)
+	}
+}
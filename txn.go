@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// txnOpKind identifies what a staged transaction operation does when applied.
+type txnOpKind string
+
+const (
+	txnOpWrite  txnOpKind = "write"
+	txnOpDelete txnOpKind = "delete"
+)
+
+// txnOp is a single staged operation, as it is staged in memory and as it is
+// persisted to the write-ahead log.
+type txnOp struct {
+	Kind       txnOpKind `json:"kind"`
+	Collection string    `json:"collection"`
+	Resource   string    `json:"resource"`
+	Data       []byte    `json:"data,omitempty"`
+}
+
+// walFile is the on-disk shape of a write-ahead log file: an ordered list of
+// ops plus a checksum over those ops, so a crash mid-write leaves either a
+// complete, verifiable file or one that fails the checksum and is discarded.
+type walFile struct {
+	Ops      []txnOp `json:"ops"`
+	Checksum string  `json:"checksum"`
+}
+
+var txnSeq uint64
+
+// Txn is a batch of Write/Delete operations staged in memory and applied
+// atomically across arbitrary collections on Commit.
+type Txn struct {
+	driver *Driver
+	id     string
+	ops    []txnOp
+}
+
+// Begin starts a new transaction. Operations staged on the returned Txn have
+// no effect on the database until Commit is called.
+func (d *Driver) Begin() *Txn {
+	seq := atomic.AddUint64(&txnSeq, 1)
+	return &Txn{
+		driver: d,
+		id:     fmt.Sprintf("%d-%d", time.Now().UnixNano(), seq),
+	}
+}
+
+// Write stages a write of resource within collection. The value is encoded
+// immediately so later mutations to v don't affect the staged operation.
+func (t *Txn) Write(collection, resource string, v interface{}) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - no place to save record!")
+	}
+	if resource == "" {
+		return fmt.Errorf("Missing resource - no key to save record!")
+	}
+
+	b, err := t.driver.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	t.ops = append(t.ops, txnOp{Kind: txnOpWrite, Collection: collection, Resource: resource, Data: b})
+	return nil
+}
+
+// Delete stages a delete of resource within collection.
+func (t *Txn) Delete(collection, resource string) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - no place to save record!")
+	}
+	if resource == "" {
+		return fmt.Errorf("Missing resource - unable to save record!")
+	}
+
+	t.ops = append(t.ops, txnOp{Kind: txnOpDelete, Collection: collection, Resource: resource})
+	return nil
+}
+
+// Commit durably applies every staged operation as a single unit. It first
+// writes and fsyncs a WAL file describing the batch, then applies each op
+// under the mutexes of every touched collection (acquired in sorted order to
+// avoid deadlocking against concurrent transactions), and finally removes
+// the WAL file now that the batch is reflected in the collections themselves.
+func (t *Txn) Commit() error {
+	if len(t.ops) == 0 {
+		return nil
+	}
+
+	walPath, err := t.driver.writeWAL(t.id, t.ops)
+	if err != nil {
+		return err
+	}
+
+	unlock := t.driver.lockCollections(t.collections())
+	defer unlock()
+
+	if err := applyOps(t.driver, t.ops); err != nil {
+		return err
+	}
+
+	return os.Remove(walPath)
+}
+
+// collections returns the distinct collections touched by t, in no
+// particular order; lockCollections is responsible for sorting them.
+func (t *Txn) collections() []string {
+	return collectionsOf(t.ops)
+}
+
+// walDir returns the directory holding this driver's write-ahead log files.
+func (d *Driver) walDir() string {
+	return filepath.Join(d.dir, ".wal")
+}
+
+// writeWAL persists ops as a checksummed WAL file named after id and fsyncs
+// it before returning, so a crash immediately after this call still leaves a
+// replayable record of the batch on disk.
+func (d *Driver) writeWAL(id string, ops []txnOp) (string, error) {
+	walDir := d.walDir()
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		return "", err
+	}
+
+	sum, err := checksumOps(ops)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(walFile{Ops: ops, Checksum: sum})
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(walDir, id+".log")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(b); err != nil {
+		return "", err
+	}
+	return path, f.Sync()
+}
+
+// checksumOps computes a stable checksum over ops, independent of the
+// Checksum field itself, so it can be recomputed on replay to tell a
+// complete WAL file from a partially-written one.
+func checksumOps(ops []txnOp) (string, error) {
+	b, err := json.Marshal(ops)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lockCollections takes each collection's lock for write, in sorted order,
+// so two transactions touching overlapping collections always lock them in
+// the same relative order and can never deadlock. A transaction holds the
+// full collection lock (rather than per-resource locks) since it doesn't
+// know its resources' final set up front across arbitrary ops. It returns a
+// function that releases the locks in reverse order.
+func (d *Driver) lockCollections(collections []string) func() {
+	sorted := append([]string(nil), collections...)
+	sort.Strings(sorted)
+
+	locks := make([]*sync.RWMutex, len(sorted))
+	for i, collection := range sorted {
+		locks[i] = d.getCollectionLock(collection)
+		locks[i].Lock()
+	}
+
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
+// applyOps applies ops, in order, to d's database, keeping any registered
+// indexes in sync with each write or delete as it lands.
+func applyOps(d *Driver, ops []txnOp) error {
+	for _, op := range ops {
+		switch op.Kind {
+		case txnOpWrite:
+			if err := writeRecord(d.dir, op.Collection, op.Resource, d.codec.Ext(), op.Data); err != nil {
+				return err
+			}
+			d.updateIndexesOnWrite(op.Collection, op.Resource, op.Data)
+		case txnOpDelete:
+			if err := deleteRecord(d.dir, op.Collection, op.Resource, d.codec.Ext()); err != nil {
+				return err
+			}
+			d.updateIndexesOnDelete(op.Collection, op.Resource)
+		default:
+			return fmt.Errorf("unknown transaction op kind %q", op.Kind)
+		}
+	}
+	return nil
+}
+
+// recoverWAL replays any complete (checksum-valid) write-ahead log files left
+// behind by a crash during Commit, and discards any partial ones. It is
+// called once, from New.
+func (d *Driver) recoverWAL() {
+	entries, err := ioutil.ReadDir(d.walDir())
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".log" {
+			continue
+		}
+
+		path := filepath.Join(d.walDir(), entry.Name())
+		if err := d.recoverWALFile(path); err != nil {
+			d.log.Error("Discarding incomplete WAL file '%s': %v\n", path, err)
+		}
+		os.Remove(path)
+	}
+}
+
+// recoverWALFile validates and, if valid, replays a single WAL file.
+func (d *Driver) recoverWALFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var wal walFile
+	if err := json.Unmarshal(b, &wal); err != nil {
+		return err
+	}
+
+	sum, err := checksumOps(wal.Ops)
+	if err != nil {
+		return err
+	}
+	if sum != wal.Checksum {
+		return fmt.Errorf("checksum mismatch")
+	}
+
+	unlock := d.lockCollections(collectionsOf(wal.Ops))
+	defer unlock()
+
+	return applyOps(d, wal.Ops)
+}
+
+// collectionsOf returns the distinct collections touched by ops.
+func collectionsOf(ops []txnOp) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, op := range ops {
+		if !seen[op.Collection] {
+			seen[op.Collection] = true
+			out = append(out, op.Collection)
+		}
+	}
+	return out
+}
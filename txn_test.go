@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTxnCommitAppliesAcrossCollections is the happy path: a single Commit
+// lands writes and deletes spanning two different collections as one unit,
+// and leaves no WAL file behind once it's durable.
+func TestTxnCommitAppliesAcrossCollections(t *testing.T) {
+	dir := t.TempDir()
+	db, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if err := db.Write("users", "bob", map[string]string{"name": "bob"}); err != nil {
+		t.Fatalf("seed Write: %v", err)
+	}
+
+	txn := db.Begin()
+	if err := txn.Write("users", "alice", map[string]string{"name": "alice"}); err != nil {
+		t.Fatalf("Txn.Write: %v", err)
+	}
+	if err := txn.Write("orders", "o1", map[string]string{"user": "alice"}); err != nil {
+		t.Fatalf("Txn.Write: %v", err)
+	}
+	if err := txn.Delete("users", "bob"); err != nil {
+		t.Fatalf("Txn.Delete: %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	var alice map[string]string
+	if err := db.Read("users", "alice", &alice); err != nil {
+		t.Fatalf("Read alice: %v", err)
+	}
+	if alice["name"] != "alice" {
+		t.Fatalf("alice = %v, want name=alice", alice)
+	}
+
+	if err := db.Read("users", "bob", &map[string]string{}); err == nil {
+		t.Fatalf("bob should have been deleted by the transaction")
+	}
+
+	entries, err := os.ReadDir(db.walDir())
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("ReadDir(walDir): %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("WAL dir should be empty after a successful Commit, got %v", entries)
+	}
+}
+
+// TestWALRecoversCompleteFileAfterCrash simulates a crash between writeWAL
+// and the removal of the WAL file in Commit: a valid, checksummed WAL file
+// is left on disk with no corresponding collection files. Opening the
+// database again must replay it.
+func TestWALRecoversCompleteFileAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	db, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	data, err := json.Marshal(map[string]string{"name": "carol"})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	ops := []txnOp{{Kind: txnOpWrite, Collection: "users", Resource: "carol", Data: data}}
+
+	if _, err := db.writeWAL("crash-sim", ops); err != nil {
+		t.Fatalf("writeWAL: %v", err)
+	}
+
+	db2, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("reopen New: %v", err)
+	}
+
+	var carol map[string]string
+	if err := db2.Read("users", "carol", &carol); err != nil {
+		t.Fatalf("carol should have been replayed from the WAL: %v", err)
+	}
+	if carol["name"] != "carol" {
+		t.Fatalf("carol = %v, want name=carol", carol)
+	}
+
+	if _, err := os.Stat(filepath.Join(db2.walDir(), "crash-sim.log")); !os.IsNotExist(err) {
+		t.Fatalf("replayed WAL file should have been removed, stat err = %v", err)
+	}
+}
+
+// TestWALDiscardsCorruptFile simulates a crash mid-write to the WAL file
+// itself: the checksum no longer matches its ops. Recovery must discard it
+// (not apply a possibly-truncated op) rather than failing New.
+func TestWALDiscardsCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	db, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	data, err := json.Marshal(map[string]string{"name": "dave"})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	ops := []txnOp{{Kind: txnOpWrite, Collection: "users", Resource: "dave", Data: data}}
+	walPath, err := db.writeWAL("corrupt-sim", ops)
+	if err != nil {
+		t.Fatalf("writeWAL: %v", err)
+	}
+
+	// Corrupt the file so its checksum no longer matches its ops, as a
+	// partial write mid-crash would leave it.
+	if err := os.WriteFile(walPath, []byte(`{"ops":[],"checksum":"deadbeef"}`), 0644); err != nil {
+		t.Fatalf("corrupt WAL file: %v", err)
+	}
+
+	db2, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("reopen New: %v", err)
+	}
+
+	if err := db2.Read("users", "dave", &map[string]string{}); err == nil {
+		t.Fatalf("dave should not exist: a corrupt WAL file must not be replayed")
+	}
+	if _, err := os.Stat(walPath); !os.IsNotExist(err) {
+		t.Fatalf("corrupt WAL file should have been removed, stat err = %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// EventOp identifies what changed a watched resource.
+type EventOp string
+
+const (
+	EventWrite  EventOp = "write"
+	EventDelete EventOp = "delete"
+)
+
+// Event describes a single change to a resource, delivered by Watch/WatchAll.
+type Event struct {
+	Op         EventOp
+	Collection string
+	Resource   string
+	Data       []byte
+}
+
+// collectionWatcher fans a single collection directory's filesystem events
+// out to every channel subscribed via Watch.
+type collectionWatcher struct {
+	driver     *Driver
+	collection string
+	fsWatcher  *fsnotify.Watcher
+
+	mu   sync.Mutex
+	subs []chan Event
+}
+
+// Watch returns a channel of Events for every Write/Delete to collection,
+// and a function to stop watching. The fsnotify watcher on the collection's
+// directory is started lazily on first use. The returned channel is never
+// closed, even after the stop function is called, so callers must not range
+// over it expecting the loop to end on its own; select against the stop
+// function's effect (e.g. a separate done channel) instead.
+func (d *Driver) Watch(collection string) (<-chan Event, func(), error) {
+	if collection == "" {
+		return nil, nil, fmt.Errorf("Missing collection - nothing to watch!")
+	}
+
+	cw, err := d.collectionWatcher(collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan Event, 16)
+	cw.mu.Lock()
+	cw.subs = append(cw.subs, ch)
+	cw.mu.Unlock()
+
+	return ch, func() { cw.unsubscribe(ch) }, nil
+}
+
+// WatchAll returns a channel of Events across every collection, present and
+// future, and a function to stop watching. As with Watch, the returned
+// channel is never closed, so don't range over it expecting termination.
+func (d *Driver) WatchAll() (<-chan Event, func(), error) {
+	if err := d.ensureRootWatcher(); err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := ioutil.ReadDir(d.dir)
+	if err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if _, err := d.collectionWatcher(entry.Name()); err != nil {
+				d.log.Error("Watch error starting watcher for '%s': %v\n", entry.Name(), err)
+			}
+		}
+	}
+
+	ch := make(chan Event, 16)
+	d.allSubsMu.Lock()
+	d.allSubs = append(d.allSubs, ch)
+	d.allSubsMu.Unlock()
+
+	// Like collectionWatcher.unsubscribe, this deliberately doesn't close ch:
+	// emit sends to allSubs outside of allSubsMu, so closing here could race
+	// a concurrent send and panic. ch is just abandoned once removed.
+	unsubscribe := func() {
+		d.allSubsMu.Lock()
+		for i, s := range d.allSubs {
+			if s == ch {
+				d.allSubs = append(d.allSubs[:i], d.allSubs[i+1:]...)
+				break
+			}
+		}
+		d.allSubsMu.Unlock()
+	}
+	return ch, unsubscribe, nil
+}
+
+// collectionWatcher returns the lazily-started watcher for collection,
+// creating its directory and fsnotify watch the first time it's needed.
+func (d *Driver) collectionWatcher(collection string) (*collectionWatcher, error) {
+	d.watchMu.Lock()
+	defer d.watchMu.Unlock()
+
+	if d.watchers == nil {
+		d.watchers = make(map[string]*collectionWatcher)
+	}
+	if cw, ok := d.watchers[collection]; ok {
+		return cw, nil
+	}
+
+	dir := filepath.Join(d.dir, collection)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := fsWatcher.Add(dir); err != nil {
+		fsWatcher.Close()
+		return nil, err
+	}
+
+	cw := &collectionWatcher{driver: d, collection: collection, fsWatcher: fsWatcher}
+	d.watchers[collection] = cw
+	go cw.run()
+	return cw, nil
+}
+
+// ensureRootWatcher lazily starts a watcher on the database directory
+// itself, so WatchAll notices collections created after it was called.
+func (d *Driver) ensureRootWatcher() error {
+	d.watchMu.Lock()
+	defer d.watchMu.Unlock()
+
+	if d.rootWatcher != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(d.dir); err != nil {
+		w.Close()
+		return err
+	}
+
+	d.rootWatcher = w
+	go d.runRootWatcher(w)
+	return nil
+}
+
+func (d *Driver) runRootWatcher(w *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Create == 0 {
+				continue
+			}
+			info, err := os.Stat(event.Name)
+			if err != nil || !info.IsDir() {
+				continue
+			}
+			collection := filepath.Base(event.Name)
+			if _, err := d.collectionWatcher(collection); err != nil {
+				d.log.Error("Watch error starting watcher for new collection '%s': %v\n", collection, err)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			d.log.Error("Watch error: %v\n", err)
+		}
+	}
+}
+
+// unsubscribe removes ch from cw.subs so emit stops sending to it. It
+// deliberately does not close ch: emit takes its subscriber snapshot under
+// cw.mu but sends outside the lock, so a concurrent send could otherwise
+// race a close and panic. Once removed here, ch is simply abandoned and
+// collected once the caller drops its reference.
+func (cw *collectionWatcher) unsubscribe(ch chan Event) {
+	cw.mu.Lock()
+	for i, s := range cw.subs {
+		if s == ch {
+			cw.subs = append(cw.subs[:i], cw.subs[i+1:]...)
+			break
+		}
+	}
+	cw.mu.Unlock()
+}
+
+func (cw *collectionWatcher) run() {
+	for {
+		select {
+		case event, ok := <-cw.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			cw.handle(event)
+		case err, ok := <-cw.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			cw.driver.log.Error("Watch error on '%s': %v\n", cw.collection, err)
+		}
+	}
+}
+
+// handle translates a raw filesystem event into an Event and fans it out.
+// Writes land as a rename of a ".tmp" staging file into the final name
+// (the atomic commit point in writeRecord), which fsnotify reports as a
+// Create of that final name; deletes land as a Remove of it.
+func (cw *collectionWatcher) handle(event fsnotify.Event) {
+	ext := cw.driver.codec.Ext()
+	name := filepath.Base(event.Name)
+	if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ext) {
+		return
+	}
+	resource := strings.TrimSuffix(name, ext)
+
+	switch {
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		cw.emit(Event{Op: EventDelete, Collection: cw.collection, Resource: resource})
+
+	case event.Op&fsnotify.Create != 0:
+		resLock := cw.driver.getResourceLock(cw.collection, resource)
+		resLock.RLock()
+		data, err := ioutil.ReadFile(event.Name)
+		resLock.RUnlock()
+		if err != nil {
+			return
+		}
+		cw.emit(Event{Op: EventWrite, Collection: cw.collection, Resource: resource, Data: data})
+	}
+}
+
+func (cw *collectionWatcher) emit(e Event) {
+	cw.mu.Lock()
+	subs := append([]chan Event(nil), cw.subs...)
+	cw.mu.Unlock()
+	deliver(subs, e)
+
+	d := cw.driver
+	d.allSubsMu.Lock()
+	allSubs := append([]chan Event(nil), d.allSubs...)
+	d.allSubsMu.Unlock()
+	deliver(allSubs, e)
+}
+
+// deliver sends e to every channel, dropping it for any subscriber that
+// isn't keeping up rather than blocking the watcher goroutine.
+func deliver(subs []chan Event, e Event) {
+	for _, ch := range subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestWatchDeliversWriteAndDelete subscribes to a collection and confirms a
+// Write and a Delete each show up as the expected Event.
+func TestWatchDeliversWriteAndDelete(t *testing.T) {
+	db, err := New(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ch, cancel, err := db.Watch("users")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	defer cancel()
+
+	if err := db.Write("users", "alice", map[string]string{"name": "alice"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	select {
+	case e := <-ch:
+		if e.Op != EventWrite || e.Collection != "users" || e.Resource != "alice" {
+			t.Fatalf("got %+v, want a Write event for users/alice", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for write event")
+	}
+
+	if err := db.Delete("users", "alice"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	select {
+	case e := <-ch:
+		if e.Op != EventDelete || e.Collection != "users" || e.Resource != "alice" {
+			t.Fatalf("got %+v, want a Delete event for users/alice", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for delete event")
+	}
+}
+
+// TestWatchIgnoresHousekeepingFiles confirms CreateIndex's manifest write
+// doesn't fan out as a bogus event to a Watch subscriber.
+func TestWatchIgnoresHousekeepingFiles(t *testing.T) {
+	db, err := New(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ch, cancel, err := db.Watch("users")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	defer cancel()
+
+	if err := db.CreateIndex("users", "Name"); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+
+	select {
+	case e := <-ch:
+		t.Fatalf("got unexpected event %+v from CreateIndex's manifest write", e)
+	case <-time.After(300 * time.Millisecond):
+	}
+}